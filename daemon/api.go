@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// Command is one entry in the REST API's route table: a path pattern
+// (with "{name}" placeholders for dynamic segments) and the Response it
+// produces on GET. This is a minimal stand-in for the full command
+// table (auth, polkit actions, UserOK, POST/PUT handlers, ...) that a
+// running daemon carries; this trimmed tree only has the routes added
+// alongside the Response types in this package.
+type Command struct {
+	PathPattern string
+	GET         func(c *Command, r *http.Request, params map[string]string) Response
+}
+
+// matches reports whether path satisfies c.PathPattern, returning the
+// values bound to any "{name}" placeholders.
+func (c *Command) matches(path string) (params map[string]string, ok bool) {
+	patSegs := strings.Split(strings.Trim(c.PathPattern, "/"), "/")
+	pathSegs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patSegs) != len(pathSegs) {
+		return nil, false
+	}
+
+	params = make(map[string]string)
+	for i, seg := range patSegs {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			params[strings.TrimSuffix(strings.TrimPrefix(seg, "{"), "}")] = pathSegs[i]
+			continue
+		}
+		if seg != pathSegs[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// metricsCmd serves GET /v2/metrics, gated by MetricsEnabled (see
+// metrics.go). Only SetMetricsEnabled turns it on; no configuration
+// option is wired to it.
+var metricsCmd = &Command{
+	PathPattern: "/v2/metrics",
+	GET: func(c *Command, r *http.Request, params map[string]string) Response {
+		return MetricsResponse{}
+	},
+}
+
+// api lists the state-independent routes this package's Response
+// additions have wired up. Routes that need the daemon's state.State
+// (like changesWatchCommand) are built by Router instead, once it has
+// one to close over.
+var api = []*Command{
+	metricsCmd,
+}
+
+// changesWatchCommand serves GET /v2/changes/{id}/watch as
+// Server-Sent Events of task progress (see response_sse_changes.go).
+func changesWatchCommand(st *state.State) *Command {
+	return &Command{
+		PathPattern: "/v2/changes/{id}/watch",
+		GET: func(c *Command, r *http.Request, params map[string]string) Response {
+			return ChangeProgressResponse(st, params["id"])
+		},
+	}
+}
+
+// Router builds the http.Handler serving every Command in api, plus the
+// routes that need access to the daemon's state.State.
+func Router(st *state.State) http.Handler {
+	routes := append([]*Command{changesWatchCommand(st)}, api...)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range routes {
+			params, ok := c.matches(r.URL.Path)
+			if !ok {
+				continue
+			}
+			if c.GET == nil || r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			c.GET(c, r, params).ServeHTTP(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	return mux
+}
@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCommandMatchesBindsPlaceholders(t *testing.T) {
+	c := &Command{PathPattern: "/v2/changes/{id}/watch"}
+
+	params, ok := c.matches("/v2/changes/42/watch")
+	if !ok {
+		t.Fatalf("matches(/v2/changes/42/watch) = false, want true")
+	}
+	if params["id"] != "42" {
+		t.Errorf("params[id] = %q, want %q", params["id"], "42")
+	}
+
+	if _, ok := c.matches("/v2/changes/42"); ok {
+		t.Errorf("matches(/v2/changes/42) = true, want false (missing /watch)")
+	}
+}
+
+func TestRouterServesMetricsOnlyWhenEnabled(t *testing.T) {
+	SetMetricsEnabled(false)
+	defer SetMetricsEnabled(false)
+
+	router := Router(nil)
+
+	req := httptest.NewRequest("GET", "/v2/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("disabled: status = %d, want 404", w.Code)
+	}
+
+	SetMetricsEnabled(true)
+	req = httptest.NewRequest("GET", "/v2/metrics", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("enabled: status = %d, want 200", w.Code)
+	}
+}
+
+func TestRouterUnknownRouteIs404(t *testing.T) {
+	router := Router(nil)
+
+	req := httptest.NewRequest("GET", "/v2/nope", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,403 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+)
+
+// metricsEnabled gates the /v2/metrics command. It defaults to off,
+// since snapd also runs on small devices that never want to pay for
+// counters they don't scrape. SetMetricsEnabled is the only switch for
+// it; no configuration option is wired to it. The command itself is
+// registered in daemon/api.go.
+var (
+	metricsEnabledMu sync.RWMutex
+	metricsEnabled   = false
+)
+
+// SetMetricsEnabled turns the /v2/metrics command, and all HTTP request
+// instrumentation, on or off.
+func SetMetricsEnabled(enabled bool) {
+	metricsEnabledMu.Lock()
+	defer metricsEnabledMu.Unlock()
+	metricsEnabled = enabled
+}
+
+// MetricsEnabled reports whether the /v2/metrics command currently
+// serves metrics or 404s.
+func MetricsEnabled() bool {
+	metricsEnabledMu.RLock()
+	defer metricsEnabledMu.RUnlock()
+	return metricsEnabled
+}
+
+// metricLabels is an ordered list of label=value pairs, used as a map
+// key (maps aren't comparable, slices of pairs sorted by name are).
+type metricLabels []string
+
+func labelKey(labels map[string]string) (metricLabels, string) {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var key metricLabels
+	var buf strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%s=%q", name, labels[name])
+		key = append(key, name, labels[name])
+	}
+	return key, buf.String()
+}
+
+// counterVec is a minimal stand-in for prometheus.CounterVec: a family
+// of monotonically increasing counters, one per distinct label set.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	sets   map[string]map[string]string
+}
+
+func newCounterVec(name, help string, labels []string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		sets:   make(map[string]map[string]string),
+	}
+}
+
+func (c *counterVec) Inc(labels map[string]string) {
+	_, key := labelKey(labels)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key]++
+	c.sets[key] = labels
+}
+
+// histogramBuckets mirrors prometheus.DefBuckets: a reasonable spread
+// for typical request-latency distributions, in seconds.
+var histogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogramVec is a minimal stand-in for prometheus.HistogramVec with a
+// fixed set of buckets.
+type histogramVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu      sync.Mutex
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+	sets    map[string]map[string]string
+}
+
+func newHistogramVec(name, help string, labels []string) *histogramVec {
+	return &histogramVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		counts: make(map[string][]uint64),
+		sums:   make(map[string]float64),
+		totals: make(map[string]uint64),
+		sets:   make(map[string]map[string]string),
+	}
+}
+
+func (h *histogramVec) Observe(labels map[string]string, v float64) {
+	_, key := labelKey(labels)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(histogramBuckets))
+		h.counts[key] = counts
+		h.sets[key] = labels
+	}
+	for i, bound := range histogramBuckets {
+		if v <= bound {
+			counts[i]++
+		}
+	}
+	h.sums[key] += v
+	h.totals[key]++
+}
+
+// counter and gauge are single, unlabeled metrics.
+type counter struct {
+	name string
+	help string
+	v    uint64 // accessed only under mu; not worth a separate atomic type here
+	mu   sync.Mutex
+}
+
+func newCounter(name, help string) *counter {
+	return &counter{name: name, help: help}
+}
+
+func (c *counter) Inc() {
+	c.mu.Lock()
+	c.v++
+	c.mu.Unlock()
+}
+
+type gauge struct {
+	name string
+	help string
+	v    int64
+	mu   sync.Mutex
+}
+
+func newGauge(name, help string) *gauge {
+	return &gauge{name: name, help: help}
+}
+
+func (g *gauge) Inc() { g.add(1) }
+func (g *gauge) Dec() { g.add(-1) }
+func (g *gauge) add(delta int64) {
+	g.mu.Lock()
+	g.v += delta
+	g.mu.Unlock()
+}
+
+var (
+	httpRequestsTotal = newCounterVec(
+		"snapd_http_requests_total",
+		"Total number of HTTP requests served by the snapd REST API.",
+		[]string{"method", "path", "status", "type"})
+
+	httpRequestDuration = newHistogramVec(
+		"snapd_http_request_duration_seconds",
+		"Latency of HTTP requests served by the snapd REST API.",
+		[]string{"method", "path", "status", "type"})
+
+	journalStreamLinesTotal = newCounter(
+		"snapd_journal_stream_lines_total",
+		"Total number of journal log lines streamed to clients.")
+
+	journalStreamActive = newGauge(
+		"snapd_journal_stream_active",
+		"Number of journal log streams currently open.")
+)
+
+// metricsPathTemplates bounds the cardinality of the "path" label: a
+// raw request path (e.g. "/v2/snaps/some-snap-name") would otherwise
+// grow the series count without limit as distinct snap names, change
+// IDs etc. flow through the API over the daemon's lifetime. Every
+// route the daemon actually serves should have an entry here; anything
+// that doesn't match falls back to "other".
+var metricsPathTemplates = []string{
+	"/v2/metrics",
+	"/v2/changes/{id}",
+	"/v2/changes/{id}/watch",
+	"/v2/logs",
+	"/v2/snaps/{name}",
+	"/v2/snaps/{name}/logs",
+	"/v2/assertions",
+	"/v2/assertions/{type}",
+}
+
+// metricsPathLabel maps a request path to its route template, so the
+// "path" label on httpRequestsTotal/httpRequestDuration stays bounded
+// regardless of how many distinct snaps, changes, etc. the daemon sees.
+func metricsPathLabel(path string) string {
+	pathSegs := strings.Split(strings.Trim(path, "/"), "/")
+	for _, tmpl := range metricsPathTemplates {
+		tmplSegs := strings.Split(strings.Trim(tmpl, "/"), "/")
+		if len(tmplSegs) != len(pathSegs) {
+			continue
+		}
+		matched := true
+		for i, seg := range tmplSegs {
+			if strings.HasPrefix(seg, "{") {
+				continue
+			}
+			if seg != pathSegs[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return tmpl
+		}
+	}
+	return "other"
+}
+
+// observeHTTPRequest records one request/response cycle for the
+// snapd_http_requests_total counter and snapd_http_request_duration_seconds
+// histogram, if metrics are enabled. typ identifies the concrete
+// Response implementation that served the request (e.g. "sync",
+// "file", "journal").
+func observeHTTPRequest(method, path string, status int, typ string, duration time.Duration) {
+	if !MetricsEnabled() {
+		return
+	}
+
+	labels := map[string]string{
+		"method": method,
+		"path":   metricsPathLabel(path),
+		"status": strconv.Itoa(status),
+		"type":   typ,
+	}
+	httpRequestsTotal.Inc(labels)
+	httpRequestDuration.Observe(labels, duration.Seconds())
+}
+
+// instrumentedResponseWriter records the status code written through
+// it, defaulting to 200 if the wrapped handler never calls WriteHeader
+// explicitly (as http.ServeFile does on its happy path).
+type instrumentedResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func newInstrumentedResponseWriter(w http.ResponseWriter) *instrumentedResponseWriter {
+	return &instrumentedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *instrumentedResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *instrumentedResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeMetrics renders the process's metrics in the Prometheus text
+// exposition format
+// (https://prometheus.io/docs/instrumenting/exposition_formats/).
+func writeMetrics(w io.Writer) error {
+	if err := writeCounterVec(w, httpRequestsTotal); err != nil {
+		return err
+	}
+	if err := writeHistogramVec(w, httpRequestDuration); err != nil {
+		return err
+	}
+	if err := writeCounter(w, journalStreamLinesTotal); err != nil {
+		return err
+	}
+	return writeGauge(w, journalStreamActive)
+}
+
+func writeCounterVec(w io.Writer, c *counterVec) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	for key, v := range c.values {
+		_, labelStr := labelKey(c.sets[key])
+		if _, err := fmt.Fprintf(w, "%s{%s} %v\n", c.name, labelStr, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHistogramVec(w io.Writer, h *histogramVec) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return err
+	}
+	for key, counts := range h.counts {
+		_, labelStr := labelKey(h.sets[key])
+		for i, bound := range histogramBuckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", h.name, labelStr, strconv.FormatFloat(bound, 'g', -1, 64), counts[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, labelStr, h.totals[key]); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum{%s} %v\n", h.name, labelStr, h.sums[key]); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, labelStr, h.totals[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCounter(w io.Writer, c *counter) error {
+	c.mu.Lock()
+	v := c.v
+	c.mu.Unlock()
+
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, v)
+	return err
+}
+
+func writeGauge(w io.Writer, g *gauge) error {
+	g.mu.Lock()
+	v := g.v
+	g.mu.Unlock()
+
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, v)
+	return err
+}
+
+// MetricsResponse serves the process's metrics as a Prometheus text
+// exposition (HTTP request counts and latencies, journal lines streamed
+// and journal streams open), for operators scraping daemon health
+// without relying on journal parsing. When MetricsEnabled() is false it
+// serves a 404 error instead.
+type MetricsResponse struct{}
+
+func (MetricsResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !MetricsEnabled() {
+		NotFound("metrics are disabled").ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := writeMetrics(w); err != nil {
+		logger.Noticef("cannot write metrics response: %v", err)
+	}
+}
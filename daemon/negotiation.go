@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// responseEncoder writes v to w in whatever wire format it implements.
+type responseEncoder func(w io.Writer, v interface{}) error
+
+// defaultMediaType is served when the request has no Accept header, or
+// the header is "*/*".
+const defaultMediaType = "application/json"
+
+// responseEncoders holds the media types resp.ServeHTTP knows how to
+// serve, keyed by media type. It is seeded with JSON; other packages
+// (e.g. ones adding YAML or msgpack support) register themselves via
+// RegisterResponseEncoder, typically from an init function.
+var responseEncoders = map[string]responseEncoder{
+	defaultMediaType: encodeJSON,
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bs)
+	return err
+}
+
+// RegisterResponseEncoder makes mediaType available as an output format
+// for content-negotiated responses, without requiring any changes to
+// resp.MarshalJSON or resp.ServeHTTP.
+func RegisterResponseEncoder(mediaType string, enc responseEncoder) {
+	responseEncoders[mediaType] = enc
+}
+
+// acceptedType is one entry parsed out of an Accept header.
+type acceptedType struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses an Accept header into the media types it names,
+// ordered from most to least preferred. Entries with an invalid or
+// missing "q" parameter default to q=1. Empty entries (e.g. from stray
+// commas) are skipped; other entries are kept as written.
+func parseAccept(header string) []acceptedType {
+	var accepted []acceptedType
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		mediaType := part
+		q := 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			mediaType = strings.TrimSpace(part[:i])
+			for _, param := range strings.Split(part[i+1:], ";") {
+				param = strings.TrimSpace(param)
+				if v, ok := cutPrefix(param, "q="); ok {
+					if f, err := strconv.ParseFloat(v, 64); err == nil {
+						q = f
+					}
+				}
+			}
+		}
+
+		accepted = append(accepted, acceptedType{mediaType: mediaType, q: q})
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].q > accepted[j].q
+	})
+	return accepted
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return "", false
+	}
+	return s[len(prefix):], true
+}
+
+// negotiateMediaType picks the best media type to serve a response in,
+// given the request's Accept header and the set of types registered via
+// RegisterResponseEncoder. It returns "" if none of the offered types
+// are acceptable, in which case the caller should respond 406 Not
+// Acceptable.
+func negotiateMediaType(r *http.Request) string {
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return defaultMediaType
+	}
+
+	for _, a := range parseAccept(header) {
+		if a.q <= 0 {
+			// q=0 (or a negative q) means "not acceptable", per RFC
+			// 7231 5.3.1 -- it must exclude the type, not just rank
+			// it last.
+			continue
+		}
+		if strings.HasSuffix(a.mediaType, "/*") {
+			// both "*/*" and a bare "type/*" range resolve to our
+			// default, deterministically. Matching against
+			// responseEncoders (a map) here would otherwise pick
+			// whichever registered format Go's map iteration order
+			// happened to visit first.
+			return defaultMediaType
+		}
+		if _, ok := responseEncoders[a.mediaType]; ok {
+			return a.mediaType
+		}
+	}
+
+	return ""
+}
+
+// acceptsMediaType reports whether header (an Accept header value) names
+// mediaType explicitly, with a non-zero q. Ranges such as "type/*" and
+// "*/*" do not count, and an empty header accepts nothing, so callers
+// can use it to gate opt-in behaviour that must not kick in for
+// ordinary clients.
+func acceptsMediaType(header, mediaType string) bool {
+	for _, a := range parseAccept(header) {
+		if a.q <= 0 {
+			continue
+		}
+		if a.mediaType == mediaType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseAcceptOrdersByQ(t *testing.T) {
+	got := parseAccept("application/json;q=0.5, application/yaml, application/msgpack;q=0.9")
+	want := []string{"application/yaml", "application/msgpack", "application/json"}
+	if len(got) != len(want) {
+		t.Fatalf("parseAccept returned %d entries, want %d: %#v", len(got), len(want), got)
+	}
+	for i, mediaType := range want {
+		if got[i].mediaType != mediaType {
+			t.Errorf("entry %d = %q, want %q", i, got[i].mediaType, mediaType)
+		}
+	}
+}
+
+func TestParseAcceptSkipsMalformedEntries(t *testing.T) {
+	got := parseAccept(", application/json ,, application/yaml;q=bogus")
+	if len(got) != 2 {
+		t.Fatalf("parseAccept returned %d entries, want 2: %#v", len(got), got)
+	}
+	// an unparsable q defaults to 1, same as no q at all.
+	if got[0].q != 1 || got[1].q != 1 {
+		t.Errorf("expected both entries to default to q=1, got %#v", got)
+	}
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", defaultMediaType},
+		{"*/*", defaultMediaType},
+		{"application/yaml", "application/yaml"},
+		{"application/yaml;q=0.1, application/json;q=0.9", "application/json"},
+		{"text/plain", ""},
+		{"application/json;q=0", ""},
+		{"application/json;q=0, application/yaml", "application/yaml"},
+		{"application/*", defaultMediaType},
+		{"text/*", defaultMediaType},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		if got := negotiateMediaType(req); got != tc.want {
+			t.Errorf("negotiateMediaType(Accept: %q) = %q, want %q", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestAcceptsMediaType(t *testing.T) {
+	tests := []struct {
+		header    string
+		mediaType string
+		want      bool
+	}{
+		{"", "application/problem+json", false},
+		{"application/problem+json", "application/problem+json", true},
+		{"application/*", "application/problem+json", false},
+		{"*/*", "application/problem+json", false},
+		{"application/json", "application/problem+json", false},
+		{"application/problem+json;q=0", "application/problem+json", false},
+	}
+
+	for _, tc := range tests {
+		if got := acceptsMediaType(tc.header, tc.mediaType); got != tc.want {
+			t.Errorf("acceptsMediaType(%q, %q) = %v, want %v", tc.header, tc.mediaType, got, tc.want)
+		}
+	}
+}
+
+func TestRegisterResponseEncoderIsPickedByNegotiation(t *testing.T) {
+	const mediaType = "application/x-test-encoding"
+	RegisterResponseEncoder(mediaType, encodeJSON)
+	defer delete(responseEncoders, mediaType)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", mediaType)
+	if got := negotiateMediaType(req); got != mediaType {
+		t.Errorf("negotiateMediaType = %q, want %q", got, mediaType)
+	}
+}
@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// problemMediaType is the RFC 7807 media type. Clients that send it in
+// their Accept header get error responses shaped as Problem Details
+// instead of the usual snapd error envelope; everyone else is
+// unaffected.
+const problemMediaType = "application/problem+json"
+
+// problemBaseURL is where the "type" member of a Problem Details
+// response points to, one document per errorKind.
+const problemBaseURL = "https://snapcraft.io/docs/errors/"
+
+// problemDetails is the RFC 7807 (https://tools.ietf.org/html/rfc7807)
+// representation of an error response. Kind and Value are extension
+// members carrying the same information snapd clients already get from
+// errorResult, so tooling that only understands Problem Details still
+// has everything a snapd-aware client would.
+type problemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	Kind  errorKind  `json:"kind,omitempty"`
+	Value errorValue `json:"value,omitempty"`
+}
+
+// problemTitles gives every errorKind a short, stable title to use in
+// Problem Details responses.
+var problemTitles = map[errorKind]string{
+	errorKindTwoFactorRequired: "two-factor authentication required",
+	errorKindTwoFactorFailed:   "two-factor authentication failed",
+	errorKindLoginRequired:     "login required",
+	errorKindInvalidAuthData:   "invalid authentication data",
+	errorKindTermsNotAccepted:  "terms of service not accepted",
+	errorKindNoPaymentMethods:  "no payment methods",
+	errorKindPaymentDeclined:   "payment declined",
+	errorKindPasswordPolicy:    "password does not meet policy",
+
+	errorKindSnapAlreadyInstalled:  "snap already installed",
+	errorKindSnapNotInstalled:      "snap not installed",
+	errorKindSnapNotFound:          "snap not found",
+	errorKindAppNotFound:           "app not found",
+	errorKindSnapLocal:             "cannot find local snap",
+	errorKindSnapNoUpdateAvailable: "snap has no update available",
+
+	errorKindNotSnap: "not a snap",
+
+	errorKindSnapNeedsDevMode:       "snap needs devmode",
+	errorKindSnapNeedsClassic:       "snap needs classic confinement",
+	errorKindSnapNeedsClassicSystem: "snap needs classic system",
+
+	errorKindBadQuery: "bad query",
+
+	errorKindNetworkTimeout: "network timeout",
+}
+
+// problemFor returns the "type" URI and "title" to use for a Problem
+// Details response carrying the given errorKind. An empty kind (the
+// common case for plain errorResponder errors) maps to "about:blank",
+// per the RFC 7807 recommendation that the title then match the HTTP
+// status phrase.
+func problemFor(kind errorKind) (typeURI, title string) {
+	if kind == "" {
+		return "about:blank", ""
+	}
+	if title, ok := problemTitles[kind]; ok {
+		return problemBaseURL + string(kind), title
+	}
+	return problemBaseURL + string(kind), string(kind)
+}
+
+// serveProblem writes r as an RFC 7807 Problem Details response.
+func (r *resp) serveProblem(w http.ResponseWriter, status int) {
+	res, ok := r.Result.(*errorResult)
+	if !ok {
+		res = &errorResult{Message: http.StatusText(status)}
+	}
+
+	typeURI, title := problemFor(res.Kind)
+	if title == "" {
+		title = http.StatusText(status)
+	}
+
+	w.Header().Set("Content-Type", problemMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problemDetails{
+		Type:   typeURI,
+		Title:  title,
+		Status: status,
+		Detail: res.Message,
+		Kind:   res.Kind,
+		Value:  res.Value,
+	})
+}
@@ -0,0 +1,125 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// every errorKind constant must have a problemTitles entry, or Problem
+// Details responses for it silently fall back to the raw kind string as
+// a title.
+var allErrorKinds = []errorKind{
+	errorKindTwoFactorRequired,
+	errorKindTwoFactorFailed,
+	errorKindLoginRequired,
+	errorKindInvalidAuthData,
+	errorKindTermsNotAccepted,
+	errorKindNoPaymentMethods,
+	errorKindPaymentDeclined,
+	errorKindPasswordPolicy,
+	errorKindSnapAlreadyInstalled,
+	errorKindSnapNotInstalled,
+	errorKindSnapNotFound,
+	errorKindAppNotFound,
+	errorKindSnapLocal,
+	errorKindSnapNoUpdateAvailable,
+	errorKindNotSnap,
+	errorKindSnapNeedsDevMode,
+	errorKindSnapNeedsClassic,
+	errorKindSnapNeedsClassicSystem,
+	errorKindBadQuery,
+	errorKindNetworkTimeout,
+}
+
+func TestProblemTitlesCoversEveryErrorKind(t *testing.T) {
+	for _, kind := range allErrorKinds {
+		if _, ok := problemTitles[kind]; !ok {
+			t.Errorf("errorKind %q has no entry in problemTitles", kind)
+		}
+	}
+}
+
+func TestProblemForEmptyKind(t *testing.T) {
+	typeURI, title := problemFor("")
+	if typeURI != "about:blank" {
+		t.Errorf("problemFor(\"\") type = %q, want \"about:blank\"", typeURI)
+	}
+	if title != "" {
+		t.Errorf("problemFor(\"\") title = %q, want empty (caller falls back to the HTTP status phrase)", title)
+	}
+}
+
+func TestProblemForKnownKind(t *testing.T) {
+	typeURI, title := problemFor(errorKindSnapNotFound)
+	if !strings.HasSuffix(typeURI, string(errorKindSnapNotFound)) {
+		t.Errorf("problemFor type = %q, want suffix %q", typeURI, errorKindSnapNotFound)
+	}
+	if title != problemTitles[errorKindSnapNotFound] {
+		t.Errorf("problemFor title = %q, want %q", title, problemTitles[errorKindSnapNotFound])
+	}
+}
+
+func TestServeProblem(t *testing.T) {
+	r := &resp{Result: &errorResult{Message: "no such snap", Kind: errorKindSnapNotFound}}
+
+	w := httptest.NewRecorder()
+	r.serveProblem(w, http.StatusNotFound)
+
+	if got := w.Header().Get("Content-Type"); got != problemMediaType {
+		t.Errorf("Content-Type = %q, want %q", got, problemMediaType)
+	}
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	var got problemDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("cannot decode response body: %v", err)
+	}
+	if got.Kind != errorKindSnapNotFound {
+		t.Errorf("Kind = %q, want %q", got.Kind, errorKindSnapNotFound)
+	}
+	if got.Title != problemTitles[errorKindSnapNotFound] {
+		t.Errorf("Title = %q, want %q", got.Title, problemTitles[errorKindSnapNotFound])
+	}
+	if got.Detail != "no such snap" {
+		t.Errorf("Detail = %q, want %q", got.Detail, "no such snap")
+	}
+}
+
+func TestServeProblemFallsBackWhenResultIsNotAnErrorResult(t *testing.T) {
+	r := &resp{Result: "not an errorResult"}
+
+	w := httptest.NewRecorder()
+	r.serveProblem(w, http.StatusInternalServerError)
+
+	var got problemDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("cannot decode response body: %v", err)
+	}
+	if got.Title != http.StatusText(http.StatusInternalServerError) {
+		t.Errorf("Title = %q, want %q", got.Title, http.StatusText(http.StatusInternalServerError))
+	}
+}
@@ -21,6 +21,7 @@ package daemon
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,6 +29,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/client"
@@ -64,22 +66,22 @@ type resp struct {
 //      The right code style takes a bit more work and unifies
 //      these fields inside resp.
 type Meta struct {
-	Sources           []string `json:"sources,omitempty"`
-	Paging            *Paging  `json:"paging,omitempty"`
-	SuggestedCurrency string   `json:"suggested-currency,omitempty"`
-	Change            string   `json:"change,omitempty"`
+	Sources           []string `json:"sources,omitempty" yaml:"sources,omitempty"`
+	Paging            *Paging  `json:"paging,omitempty" yaml:"paging,omitempty"`
+	SuggestedCurrency string   `json:"suggested-currency,omitempty" yaml:"suggested-currency,omitempty"`
+	Change            string   `json:"change,omitempty" yaml:"change,omitempty"`
 }
 
 type Paging struct {
-	Page  int `json:"page"`
-	Pages int `json:"pages"`
+	Page  int `json:"page" yaml:"page"`
+	Pages int `json:"pages" yaml:"pages"`
 }
 
 type respJSON struct {
-	Type       ResponseType `json:"type"`
-	Status     int          `json:"status-code"`
-	StatusText string       `json:"status"`
-	Result     interface{}  `json:"result"`
+	Type       ResponseType `json:"type" yaml:"type"`
+	Status     int          `json:"status-code" yaml:"status-code"`
+	StatusText string       `json:"status" yaml:"status"`
+	Result     interface{}  `json:"result" yaml:"result"`
 	*Meta
 }
 
@@ -93,14 +95,12 @@ func (r *resp) MarshalJSON() ([]byte, error) {
 	})
 }
 
-func (r *resp) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+func (r *resp) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
 	status := r.Status
-	bs, err := r.MarshalJSON()
-	if err != nil {
-		logger.Noticef("cannot marshal %#v to JSON: %v", *r, err)
-		bs = nil
-		status = 500
-	}
+	defer func() {
+		observeHTTPRequest(req.Method, req.URL.Path, status, string(r.Type), time.Since(start))
+	}()
 
 	hdr := w.Header()
 	if r.Status == 202 || r.Status == 201 {
@@ -113,9 +113,40 @@ func (r *resp) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 		}
 	}
 
-	hdr.Set("Content-Type", "application/json")
+	if r.Type == ResponseTypeError && acceptsMediaType(req.Header.Get("Accept"), problemMediaType) {
+		r.serveProblem(w, status)
+		return
+	}
+
+	mediaType := negotiateMediaType(req)
+	if mediaType == "" {
+		status = http.StatusNotAcceptable
+		hdr.Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		encodeJSON(w, &errorResult{Message: "cannot satisfy requested content type"})
+		return
+	}
+
+	body := respJSON{
+		Type:       r.Type,
+		Status:     r.Status,
+		StatusText: http.StatusText(r.Status),
+		Result:     r.Result,
+		Meta:       r.Meta,
+	}
+
+	var buf bytes.Buffer
+	if err := responseEncoders[mediaType](&buf, body); err != nil {
+		logger.Noticef("cannot marshal %#v to %s: %v", body, mediaType, err)
+		status = 500
+		mediaType = defaultMediaType
+		buf.Reset()
+		encodeJSON(&buf, body)
+	}
+
+	hdr.Set("Content-Type", mediaType)
 	w.WriteHeader(status)
-	w.Write(bs)
+	w.Write(buf.Bytes())
 }
 
 type errorKind string
@@ -151,9 +182,9 @@ const (
 type errorValue interface{}
 
 type errorResult struct {
-	Message string     `json:"message"` // note no omitempty
-	Kind    errorKind  `json:"kind,omitempty"`
-	Value   errorValue `json:"value,omitempty"`
+	Message string     `json:"message" yaml:"message"` // note no omitempty
+	Kind    errorKind  `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Value   errorValue `json:"value,omitempty" yaml:"value,omitempty"`
 }
 
 // SyncResponse builds a "sync" response from the given result.
@@ -206,71 +237,155 @@ type FileResponse string
 
 // ServeHTTP from the Response interface
 func (f FileResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	iw := newInstrumentedResponseWriter(w)
+	defer func() {
+		observeHTTPRequest(r.Method, r.URL.Path, iw.status, "file", time.Since(start))
+	}()
+
 	filename := fmt.Sprintf("attachment; filename=%s", filepath.Base(string(f)))
-	w.Header().Add("Content-Disposition", filename)
-	http.ServeFile(w, r, string(f))
+	iw.Header().Add("Content-Disposition", filename)
+	http.ServeFile(iw, r, string(f))
 }
 
-// A journalLineReaderSeqResponse's ServeHTTP method reads lines (presumed to
-// be, each one on its own, a JSON dump of a systemd.Log, as output by
-// journalctl -o json) from an io.ReadCloser, loads that into a client.Log, and
-// outputs the json dump of that, padded with RS and LF to make it a valid
-// json-seq response.
-//
-// The reader is always closed when done (this is important for
-// osutil.WatingStdoutPipe).
-//
-// Tip: “jq” knows how to read this; “jq --seq” both reads and writes this.
-type journalLineReaderSeqResponse struct {
-	io.ReadCloser
-	follow bool
+// logSink is where a journalLineReaderSeqResponse sends each parsed log
+// entry, letting the decode loop stay the same across wire transports
+// (json-seq, WebSocket, ...).
+type logSink interface {
+	WriteLog(log client.Log) error
+	Flush() error
 }
 
-func (rr *journalLineReaderSeqResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json-seq")
-
-	flusher, hasFlusher := w.(http.Flusher)
+// decodeJournalLines reads lines (presumed to be, each one on its own, a
+// JSON dump of a systemd.Log, as output by journalctl -o json) from rc,
+// loads each into a client.Log, and hands it to sink. rc is always
+// closed when done (this is important for osutil.WaitingStdoutPipe).
+func decodeJournalLines(rc io.ReadCloser, sink logSink) error {
+	defer rc.Close()
 
-	var err error
-	dec := json.NewDecoder(rr)
-	writer := bufio.NewWriter(w)
-	enc := json.NewEncoder(writer)
+	dec := json.NewDecoder(rc)
 	for {
 		var log systemd.Log
-		if err = dec.Decode(&log); err != nil {
-			break
+		if err := dec.Decode(&log); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
 		}
 
-		writer.WriteByte(0x1E) // RS -- see ascii(7), and RFC7464
-
-		// ignore the error...
 		t, _ := log.Time()
-		if err = enc.Encode(client.Log{
+		if err := sink.WriteLog(client.Log{
 			Timestamp: t,
 			Message:   log.Message(),
 			SID:       log.SID(),
 			PID:       log.PID(),
 		}); err != nil {
-			break
+			return err
 		}
-
-		if rr.follow {
-			if e := writer.Flush(); e != nil {
-				break
-			}
-			if hasFlusher {
-				flusher.Flush()
-			}
+		if err := sink.Flush(); err != nil {
+			return err
 		}
 	}
-	if err != nil && err != io.EOF {
-		fmt.Fprintf(writer, `\x1E{"error": %q}\n`, err)
+}
+
+// countingLogSink wraps another logSink to record each line written
+// through it in the snapd_journal_stream_lines_total counter, so all
+// journal transports (json-seq, WebSocket, SSE, ...) are measured the
+// same way.
+type countingLogSink struct {
+	logSink
+}
+
+func (s countingLogSink) WriteLog(log client.Log) error {
+	if MetricsEnabled() {
+		journalStreamLinesTotal.Inc()
+	}
+	return s.logSink.WriteLog(log)
+}
+
+// jsonSeqSink is the logSink backing the original json-seq transport: it
+// pads each entry with RS and LF to make it a valid json-seq response.
+//
+// Tip: “jq” knows how to read this; “jq --seq” both reads and writes this.
+type jsonSeqSink struct {
+	w      *bufio.Writer
+	flush  http.Flusher
+	follow bool
+}
+
+func newJSONSeqSink(w io.Writer, follow bool) *jsonSeqSink {
+	flusher, _ := w.(http.Flusher)
+	return &jsonSeqSink{w: bufio.NewWriter(w), flush: flusher, follow: follow}
+}
+
+func (s *jsonSeqSink) WriteLog(log client.Log) error {
+	s.w.WriteByte(0x1E) // RS -- see ascii(7), and RFC7464
+	return json.NewEncoder(s.w).Encode(log)
+}
+
+func (s *jsonSeqSink) Flush() error {
+	if !s.follow {
+		return nil
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if s.flush != nil {
+		s.flush.Flush()
+	}
+	return nil
+}
+
+// A journalLineReaderSeqResponse's ServeHTTP method serves the lines read
+// from its io.ReadCloser as application/json-seq by default, as a
+// WebSocket stream of client.Log frames when the client asks to
+// upgrade, or as Server-Sent Events when the client's Accept header asks
+// for text/event-stream.
+type journalLineReaderSeqResponse struct {
+	io.ReadCloser
+	follow bool
+}
+
+func (rr *journalLineReaderSeqResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if MetricsEnabled() {
+		journalStreamActive.Inc()
+		defer journalStreamActive.Dec()
+	}
+
+	if isWebsocketUpgrade(r) {
+		defer func() {
+			observeHTTPRequest(r.Method, r.URL.Path, http.StatusSwitchingProtocols, "journal-websocket", time.Since(start))
+		}()
+		serveJournalWebsocket(w, r, rr.ReadCloser)
+		return
+	}
+
+	if acceptsMediaType(r.Header.Get("Accept"), sseMediaType) {
+		defer func() {
+			observeHTTPRequest(r.Method, r.URL.Path, http.StatusOK, "journal-sse", time.Since(start))
+		}()
+		rc := rr.ReadCloser
+		EventStreamResponse(func(lastEventID string, done <-chan struct{}) <-chan Event {
+			return journalEvents(rc, lastEventID, done)
+		}).ServeHTTP(w, r)
+		return
+	}
+
+	iw := newInstrumentedResponseWriter(w)
+	defer func() {
+		observeHTTPRequest(r.Method, r.URL.Path, iw.status, "journal", time.Since(start))
+	}()
+
+	iw.Header().Set("Content-Type", "application/json-seq")
+	jsonSink := newJSONSeqSink(iw, rr.follow)
+	if err := decodeJournalLines(rr.ReadCloser, countingLogSink{jsonSink}); err != nil {
+		fmt.Fprintf(jsonSink.w, `\x1E{"error": %q}\n`, err)
 		logger.Noticef("cannot stream response; problem reading: %v", err)
 	}
-	if err := writer.Flush(); err != nil {
+	if err := jsonSink.w.Flush(); err != nil {
 		logger.Noticef("cannot stream response; problem writing: %v", err)
 	}
-	rr.Close()
 }
 
 type assertResponse struct {
@@ -287,14 +402,26 @@ func AssertResponse(asserts []asserts.Assertion, bundle bool) Response {
 }
 
 func (ar assertResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	iw := newInstrumentedResponseWriter(w)
+	defer func() {
+		observeHTTPRequest(r.Method, r.URL.Path, iw.status, "assert", time.Since(start))
+	}()
+
 	t := asserts.MediaType
 	if ar.bundle {
 		t = mime.FormatMediaType(t, map[string]string{"bundle": "y"})
 	}
-	w.Header().Set("Content-Type", t)
-	w.Header().Set("X-Ubuntu-Assertions-Count", strconv.Itoa(len(ar.assertions)))
-	w.WriteHeader(200)
-	enc := asserts.NewEncoder(w)
+
+	// assertions are served in their own wire format regardless of
+	// Accept: unlike the JSON responses, clients don't content-negotiate
+	// this endpoint, so honoring Accept here would make ordinary clients
+	// (who send "Accept: application/json" out of habit, not as a real
+	// request for JSON-encoded assertions) get a spurious 406.
+	iw.Header().Set("Content-Type", t)
+	iw.Header().Set("X-Ubuntu-Assertions-Count", strconv.Itoa(len(ar.assertions)))
+	iw.WriteHeader(200)
+	enc := asserts.NewEncoder(iw)
 	for _, a := range ar.assertions {
 		err := enc.Encode(a)
 		if err != nil {
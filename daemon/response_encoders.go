@@ -0,0 +1,193 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// register the additional wire formats the REST API can negotiate on
+// top of the default JSON encoding. Clients ask for one of these via
+// the Accept header, e.g. "Accept: application/yaml".
+//
+// Both encoders below are deliberately dependency-free: they round-trip
+// through encoding/json rather than pulling in a YAML or MessagePack
+// library, so the REST API gains these formats without adding anything
+// to the vendor tree.
+func init() {
+	RegisterResponseEncoder("application/yaml", encodeYAML)
+	RegisterResponseEncoder("application/msgpack", encodeMsgpack)
+}
+
+// encodeYAML writes v as YAML. Every JSON document is valid YAML 1.2, so
+// it is enough to marshal v the way encodeJSON does and indent it for
+// readability; there is no need for a dedicated YAML library just to
+// re-serialize the same tree of maps, slices and scalars json.Marshal
+// already produces.
+func encodeYAML(w io.Writer, v interface{}) error {
+	bs, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bs)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("\n"))
+	return err
+}
+
+// encodeMsgpack writes v as MessagePack (https://msgpack.org/). v is
+// first round-tripped through encoding/json into plain
+// map[string]interface{}/[]interface{}/scalars, then walked by
+// writeMsgpackValue; this keeps the encoder small and lets it rely on
+// the same struct-tag/field rules encodeJSON already uses instead of
+// duplicating them via reflection.
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(bs, &generic); err != nil {
+		return err
+	}
+
+	return writeMsgpackValue(w, generic)
+}
+
+func writeMsgpackValue(w io.Writer, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		return writeMsgpackByte(w, 0xc0)
+	case bool:
+		if val {
+			return writeMsgpackByte(w, 0xc3)
+		}
+		return writeMsgpackByte(w, 0xc2)
+	case float64:
+		return writeMsgpackFloat64(w, val)
+	case string:
+		return writeMsgpackString(w, val)
+	case []interface{}:
+		return writeMsgpackArray(w, val)
+	case map[string]interface{}:
+		return writeMsgpackMap(w, val)
+	default:
+		return fmt.Errorf("cannot encode %T as msgpack", v)
+	}
+}
+
+func writeMsgpackByte(w io.Writer, b byte) error {
+	_, err := w.Write([]byte{b})
+	return err
+}
+
+func writeMsgpackFloat64(w io.Writer, f float64) error {
+	bs := make([]byte, 9)
+	bs[0] = 0xcb
+	bits := math.Float64bits(f)
+	for i := 0; i < 8; i++ {
+		bs[1+i] = byte(bits >> uint(56-8*i))
+	}
+	_, err := w.Write(bs)
+	return err
+}
+
+func writeMsgpackString(w io.Writer, s string) error {
+	n := len(s)
+	var header []byte
+	switch {
+	case n < 32:
+		header = []byte{0xa0 | byte(n)}
+	case n < 1<<8:
+		header = []byte{0xd9, byte(n)}
+	case n < 1<<16:
+		header = []byte{0xda, byte(n >> 8), byte(n)}
+	default:
+		header = []byte{0xdb, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func writeMsgpackArray(w io.Writer, a []interface{}) error {
+	n := len(a)
+	var header []byte
+	switch {
+	case n < 16:
+		header = []byte{0x90 | byte(n)}
+	case n < 1<<16:
+		header = []byte{0xdc, byte(n >> 8), byte(n)}
+	default:
+		header = []byte{0xdd, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, elem := range a {
+		if err := writeMsgpackValue(w, elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMsgpackMap(w io.Writer, m map[string]interface{}) error {
+	n := len(m)
+	var header []byte
+	switch {
+	case n < 16:
+		header = []byte{0x80 | byte(n)}
+	case n < 1<<16:
+		header = []byte{0xde, byte(n >> 8), byte(n)}
+	default:
+		header = []byte{0xdf, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// iterate keys in a fixed order so the same document always
+	// encodes to the same bytes.
+	keys := make([]string, 0, n)
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := writeMsgpackString(w, k); err != nil {
+			return err
+		}
+		if err := writeMsgpackValue(w, m[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
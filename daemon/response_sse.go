@@ -0,0 +1,207 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/logger"
+)
+
+// sseMediaType is the media type clients ask for (e.g. via Accept) to
+// get Server-Sent Events instead of a transport's default framing.
+const sseMediaType = "text/event-stream"
+
+// sseKeepAlivePeriod bounds how long an event stream can go quiet
+// before we send a keep-alive comment, so intermediate proxies don't
+// time the connection out.
+const sseKeepAlivePeriod = 15 * time.Second
+
+// Event is one Server-Sent Event. Data is JSON-encoded and, per the SSE
+// spec, split across multiple "data:" lines if the encoding contains
+// newlines.
+type Event struct {
+	ID    string
+	Event string
+	Data  interface{}
+}
+
+// eventStreamResponse serves events from the channel newEvents returns
+// as text/event-stream, with automatic keep-alive and Last-Event-ID
+// resume support. newEvents is called with the client's Last-Event-ID
+// header (empty if none) and a done channel that's closed once the
+// client goes away, so a producer blocked trying to send an event can
+// notice and stop instead of leaking.
+type eventStreamResponse struct {
+	newEvents func(lastEventID string, done <-chan struct{}) <-chan Event
+}
+
+// EventStreamResponse builds a Response that serves Server-Sent Events
+// produced by newEvents, e.g. incremental change/task progress, or (via
+// journalEvents) journal log lines.
+func EventStreamResponse(newEvents func(lastEventID string, done <-chan struct{}) <-chan Event) Response {
+	return &eventStreamResponse{newEvents: newEvents}
+}
+
+func (rs *eventStreamResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		InternalError("cannot stream: response writer does not support flushing").ServeHTTP(w, r)
+		return
+	}
+
+	hdr := w.Header()
+	hdr.Set("Content-Type", sseMediaType)
+	hdr.Set("Cache-Control", "no-cache")
+	hdr.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := rs.newEvents(r.Header.Get("Last-Event-ID"), r.Context().Done())
+
+	ticker := time.NewTicker(sseKeepAlivePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeSSEEvent(w, ev); err != nil {
+				logger.Noticef("cannot stream response; problem writing: %v", err)
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSEEvent writes ev to w using the "id:"/"event:"/"data:" framing
+// from https://html.spec.whatwg.org/multipage/server-sent-events.html.
+func writeSSEEvent(w io.Writer, ev Event) error {
+	var buf bytes.Buffer
+	if ev.ID != "" {
+		fmt.Fprintf(&buf, "id: %s\n", ev.ID)
+	}
+	if ev.Event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", ev.Event)
+	}
+
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// errStreamDone is returned by chanLogSink.WriteLog when the consumer
+// went away while a send was blocked, so the decode loop can unwind and
+// close rc instead of leaking the goroutine.
+var errStreamDone = errors.New("event stream consumer gone")
+
+// journalEvents adapts the journal decode loop (decodeJournalLines) to
+// an Event channel, so journalLineReaderSeqResponse can offer SSE as an
+// alternative to its json-seq and WebSocket transports without
+// duplicating the parsing. done is closed when the client disconnects;
+// it unblocks a pending send on the (unbuffered) events channel and
+// closes rc to unblock a pending read, so the goroutine and rc are
+// always cleaned up.
+//
+// Event IDs are a 1-based sequence number over the lines read from rc.
+// Resuming with Last-Event-ID skips re-delivering lines the client
+// already saw: the underlying journalctl pipe can't be seeked, so the
+// skipped lines are still read and decoded, just not sent.
+func journalEvents(rc io.ReadCloser, lastEventID string, done <-chan struct{}) <-chan Event {
+	resumeFrom, _ := strconv.Atoi(lastEventID)
+
+	events := make(chan Event)
+	finished := make(chan struct{})
+	go func() {
+		// a decode blocked in rc.Read (e.g. a follow-mode journal with
+		// no new lines) is only unblocked by closing rc.
+		select {
+		case <-done:
+			rc.Close()
+		case <-finished:
+		}
+	}()
+	go func() {
+		defer close(events)
+		defer close(finished)
+		sink := countingLogSink{&chanLogSink{events: events, done: done, resumeFrom: resumeFrom}}
+		if err := decodeJournalLines(rc, sink); err != nil && err != errStreamDone {
+			logger.Noticef("cannot stream response; problem reading: %v", err)
+		}
+	}()
+	return events
+}
+
+// chanLogSink is the logSink that feeds journalEvents' channel. Lines
+// whose sequence number is at or below resumeFrom (the client's
+// Last-Event-ID) are decoded, to keep the stream in sync, but not
+// re-sent.
+type chanLogSink struct {
+	events     chan<- Event
+	done       <-chan struct{}
+	seq        int
+	resumeFrom int
+}
+
+func (s *chanLogSink) WriteLog(log client.Log) error {
+	s.seq++
+	if s.seq <= s.resumeFrom {
+		return nil
+	}
+	ev := Event{ID: strconv.Itoa(s.seq), Event: "log", Data: log}
+	select {
+	case s.events <- ev:
+		return nil
+	case <-s.done:
+		return errStreamDone
+	}
+}
+
+func (s *chanLogSink) Flush() error {
+	return nil
+}
@@ -0,0 +1,137 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// changeProgressPollInterval is how often changeProgressEvents checks a
+// Change's tasks for an update. state doesn't notify on task progress,
+// so this is a poll, same as the web UI's current GET /v2/changes/{id}
+// loop, just done server-side.
+const changeProgressPollInterval = 500 * time.Millisecond
+
+// taskProgress is one task's contribution to a changeSnapshot.
+type taskProgress struct {
+	TaskID  string `json:"task-id"`
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+	Status  string `json:"status"`
+	Done    int    `json:"done"`
+	Total   int    `json:"total"`
+}
+
+// changeSnapshot is what changeProgressEvents sends as an Event's Data:
+// enough for a client to render the change without a follow-up GET.
+type changeSnapshot struct {
+	Ready bool           `json:"ready"`
+	Err   string         `json:"err,omitempty"`
+	Tasks []taskProgress `json:"tasks"`
+}
+
+// ChangeProgressResponse serves incremental state.Change task progress
+// as Server-Sent Events, so `snap watch` and web UIs can follow a
+// change without polling GET /v2/changes/{id}.
+func ChangeProgressResponse(st *state.State, changeID string) Response {
+	return EventStreamResponse(func(lastEventID string, done <-chan struct{}) <-chan Event {
+		return changeProgressEvents(st, changeID, lastEventID, done)
+	})
+}
+
+func snapshotChange(chg *state.Change) changeSnapshot {
+	snap := changeSnapshot{Ready: chg.Status().Ready()}
+	if err := chg.Err(); err != nil {
+		snap.Err = err.Error()
+	}
+	for _, t := range chg.Tasks() {
+		_, done, total := t.Progress()
+		snap.Tasks = append(snap.Tasks, taskProgress{
+			TaskID:  t.ID(),
+			Kind:    t.Kind(),
+			Summary: t.Summary(),
+			Status:  t.Status().String(),
+			Done:    done,
+			Total:   total,
+		})
+	}
+	return snap
+}
+
+// changeProgressEvents polls chg every changeProgressPollInterval and
+// emits an Event carrying its full task snapshot whenever that snapshot
+// changes, until the change is ready or the client disconnects.
+//
+// state keeps no event history, so lastEventID only continues the event
+// ID sequence: a reconnecting client always first gets the full current
+// snapshot, not a delta, and no snapshot is skipped.
+func changeProgressEvents(st *state.State, changeID string, lastEventID string, done <-chan struct{}) <-chan Event {
+	seq, _ := strconv.Atoi(lastEventID)
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(changeProgressPollInterval)
+		defer ticker.Stop()
+
+		var last changeSnapshot
+		first := true
+		for {
+			st.Lock()
+			chg := st.Change(changeID)
+			var snap changeSnapshot
+			if chg != nil {
+				snap = snapshotChange(chg)
+			}
+			st.Unlock()
+
+			if chg == nil {
+				return
+			}
+
+			if first || !reflect.DeepEqual(snap, last) {
+				seq++
+				select {
+				case events <- Event{ID: strconv.Itoa(seq), Event: "change-progress", Data: snap}:
+				case <-done:
+					return
+				}
+				last = snap
+				first = false
+			}
+
+			if snap.Ready {
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return events
+}
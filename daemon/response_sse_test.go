@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func TestWriteSSEEventSingleLineData(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeSSEEvent(&buf, Event{ID: "1", Event: "log", Data: map[string]string{"msg": "hello"}})
+	if err != nil {
+		t.Fatalf("writeSSEEvent returned error: %v", err)
+	}
+
+	want := "id: 1\nevent: log\ndata: {\"msg\":\"hello\"}\n\n"
+	if buf.String() != want {
+		t.Errorf("writeSSEEvent wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteSSEEventEscapesMultiLineData(t *testing.T) {
+	var buf bytes.Buffer
+	// a string with an embedded newline: JSON-encoding escapes it, so it
+	// stays on a single "data:" line.
+	err := writeSSEEvent(&buf, Event{Data: "line one\nline two"})
+	if err != nil {
+		t.Fatalf("writeSSEEvent returned error: %v", err)
+	}
+
+	want := "data: \"line one\\nline two\"\n\n"
+	if buf.String() != want {
+		t.Errorf("writeSSEEvent wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteSSEEventOmitsEmptyIDAndEvent(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSSEEvent(&buf, Event{Data: 42}); err != nil {
+		t.Fatalf("writeSSEEvent returned error: %v", err)
+	}
+
+	want := "data: 42\n\n"
+	if buf.String() != want {
+		t.Errorf("writeSSEEvent wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestChanLogSinkStopsOnDone(t *testing.T) {
+	events := make(chan Event) // unbuffered, nothing ever reads it
+	done := make(chan struct{})
+	close(done)
+
+	sink := &chanLogSink{events: events, done: done}
+	if err := sink.WriteLog(client.Log{Message: "hi"}); err != errStreamDone {
+		t.Errorf("WriteLog with closed done = %v, want errStreamDone", err)
+	}
+}
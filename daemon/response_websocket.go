@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/logger"
+)
+
+// websocketPingPeriod and websocketPongWait bound how long a WebSocket
+// log stream can go quiet before we consider the client gone. The ping
+// period must stay comfortably under the pong wait. websocketWriteWait
+// bounds how long a single write can block, so a half-open TCP peer
+// can't stall the ping loop (or the eventual close frame) forever.
+const (
+	websocketPingPeriod = 30 * time.Second
+	websocketPongWait   = 60 * time.Second
+	websocketWriteWait  = 10 * time.Second
+)
+
+// wsLogSink is the logSink that pushes each client.Log as a text frame
+// over a WebSocket connection. Only one frame can be in flight at a
+// time per connection, so every write goes through mu, which is shared
+// with the ping/close writes in serveJournalWebsocket.
+type wsLogSink struct {
+	conn *wsConn
+	mu   *sync.Mutex
+}
+
+func (s *wsLogSink) WriteLog(log client.Log) error {
+	bs, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+	return s.conn.WriteText(bs)
+}
+
+func (s *wsLogSink) Flush() error {
+	// every WriteLog call already sends its own frame.
+	return nil
+}
+
+// serveJournalWebsocket upgrades r to a WebSocket connection and streams
+// the journal lines read from rc over it, one client.Log per text
+// frame, until rc is exhausted or the client goes away. It shares the
+// decode loop (decodeJournalLines) with the json-seq transport, keeping
+// both in lock-step.
+func serveJournalWebsocket(w http.ResponseWriter, r *http.Request, rc io.ReadCloser) {
+	conn, err := upgradeWebsocket(w, r)
+	if err != nil {
+		logger.Noticef("cannot upgrade to websocket: %v", err)
+		rc.Close()
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(websocketPongWait))
+
+	// the client can send control frames (a pong, or a close); for now
+	// we just drain whatever comes in and notice when the connection
+	// goes away.
+	clientGone := make(chan struct{})
+	go func() {
+		defer close(clientGone)
+		for {
+			opcode, _, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			if opcode == wsOpClose {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(websocketPongWait))
+		}
+	}()
+
+	var writeMu sync.Mutex
+
+	done := make(chan error, 1)
+	go func() {
+		done <- decodeJournalLines(rc, countingLogSink{&wsLogSink{conn: conn, mu: &writeMu}})
+	}()
+
+	ticker := time.NewTicker(websocketPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil && err != io.EOF {
+				logger.Noticef("cannot stream response; problem reading: %v", err)
+			}
+			writeMu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+			conn.WriteClose()
+			writeMu.Unlock()
+			return
+		case <-ticker.C:
+			writeMu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+			err := conn.WritePing()
+			writeMu.Unlock()
+			if err != nil {
+				// the peer can't be reached. Close rc so the decode
+				// goroutine (and the journalctl subprocess behind
+				// it) unwinds instead of blocking on rc.Read()
+				// forever.
+				rc.Close()
+				return
+			}
+		case <-clientGone:
+			// same reasoning as above: once the client has gone,
+			// nothing else will ever close rc.
+			rc.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJournal is an io.ReadCloser that feeds fixed journal-style JSON
+// lines, then blocks (simulating "journalctl -f" waiting for more
+// output) until explicitly closed -- exactly the situation that used to
+// leak the decode goroutine when a WebSocket client disconnected.
+type fakeJournal struct {
+	r      io.Reader
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newFakeJournal(lines string) *fakeJournal {
+	return &fakeJournal{r: strings.NewReader(lines), closed: make(chan struct{})}
+}
+
+func (f *fakeJournal) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		// block here instead of returning EOF, like a live
+		// "journalctl -f" pipe would, until Close unblocks us.
+		<-f.closed
+		return 0, io.ErrClosedPipe
+	}
+	return n, err
+}
+
+func (f *fakeJournal) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+// dialWebsocket performs the client side of the RFC 6455 handshake over
+// a plain TCP connection to addr.
+func dialWebsocket(t *testing.T, addr, path string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	keyBytes := make([]byte, 16)
+	rand.Read(keyBytes)
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+
+	return conn
+}
+
+// readServerFrame reads one unmasked frame (as the server always sends)
+// and returns its opcode and payload.
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatalf("read frame header: %v", err)
+	}
+	opcode := head[0] & 0x0f
+	length := int(head[1] & 0x7f)
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("read frame payload: %v", err)
+	}
+	return opcode, payload
+}
+
+// writeMaskedClientFrame writes a frame the way a real client must:
+// masked, per RFC 6455 section 5.3.
+func writeMaskedClientFrame(w io.Writer, opcode byte, payload []byte) error {
+	mask := make([]byte, 4)
+	rand.Read(mask)
+
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+
+	header := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(mask); err != nil {
+		return err
+	}
+	_, err := w.Write(masked)
+	return err
+}
+
+func TestServeJournalWebsocketStreamsLogLines(t *testing.T) {
+	journal := newFakeJournal(`{"MESSAGE": "hello"}` + "\n")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveJournalWebsocket(w, r, journal)
+	}))
+	defer srv.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	conn := dialWebsocket(t, addr, "/")
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+
+	opcode, payload := readServerFrame(t, br)
+	if opcode != wsOpText {
+		t.Fatalf("opcode = %d, want text (%d)", opcode, wsOpText)
+	}
+	if !strings.Contains(string(payload), "hello") {
+		t.Errorf("payload = %q, want it to contain %q", payload, "hello")
+	}
+}
+
+func TestServeJournalWebsocketClosesJournalOnClientDisconnect(t *testing.T) {
+	// never produces a line, so the decode goroutine is always blocked
+	// in Read() until the server notices the client is gone and closes
+	// it itself.
+	journal := newFakeJournal("")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveJournalWebsocket(w, r, journal)
+	}))
+	defer srv.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	conn := dialWebsocket(t, addr, "/")
+
+	if err := writeMaskedClientFrame(conn, wsOpClose, nil); err != nil {
+		t.Fatalf("write close frame: %v", err)
+	}
+
+	select {
+	case <-journal.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("journal reader was never closed after client disconnected -- goroutine/subprocess leak")
+	}
+
+	conn.Close()
+}
@@ -0,0 +1,196 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// websocketGUID is the magic value RFC 6455 5.2.2 defines for computing
+// Sec-WebSocket-Accept from the client's Sec-WebSocket-Key.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocket opcodes, RFC 6455 section 5.2.
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xa
+)
+
+// isWebsocketUpgrade reports whether r is asking to be upgraded to a
+// WebSocket connection.
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// wsConn is a minimal server-side RFC 6455 WebSocket connection: enough
+// to send unfragmented text/ping/close frames and to read whatever the
+// client sends back. It doesn't support fragmentation or binary
+// frames, since the only producer here (the journal log streamer) only
+// ever sends single, complete JSON text messages.
+//
+// This is deliberately hand-rolled instead of pulling in
+// github.com/gorilla/websocket: that would be a new vendored
+// dependency for a protocol this small a subset of RFC 6455 already
+// covers.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// upgradeWebsocket performs the RFC 6455 handshake and hijacks the
+// underlying connection. The daemon only ever talks to local clients
+// (the snap command, or a reverse proxy terminating on the same host),
+// so there is no Origin policy to enforce.
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.New()
+	io.WriteString(h, key+websocketGUID)
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, br: buf.Reader}, nil
+}
+
+func (c *wsConn) Close() error                      { return c.conn.Close() }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+
+// writeFrame writes a single, unfragmented, unmasked frame. Servers
+// never mask the frames they send (RFC 6455 section 5.1).
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	var header []byte
+	n := len(payload)
+	switch {
+	case n < 126:
+		header = []byte{0x80 | opcode, byte(n)}
+	case n < 1<<16:
+		header = make([]byte, 4)
+		header[0] = 0x80 | opcode
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = make([]byte, 10)
+		header[0] = 0x80 | opcode
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+func (c *wsConn) WriteText(payload []byte) error { return c.writeFrame(wsOpText, payload) }
+func (c *wsConn) WritePing() error               { return c.writeFrame(wsOpPing, nil) }
+func (c *wsConn) WriteClose() error              { return c.writeFrame(wsOpClose, nil) }
+
+// ReadFrame reads one client frame and returns its opcode and unmasked
+// payload. Clients are required to mask every frame they send (RFC
+// 6455 section 5.3); an unmasked frame is a protocol violation and
+// surfaced as an error.
+func (c *wsConn) ReadFrame() (opcode byte, payload []byte, err error) {
+	head, err := c.readN(2)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	opcode = head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	if !masked {
+		return 0, nil, errors.New("received unmasked client frame")
+	}
+	length := uint64(head[1] & 0x7f)
+
+	switch length {
+	case 126:
+		ext, err := c.readN(2)
+		if err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext, err := c.readN(8)
+		if err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+
+	maskKey, err := c.readN(4)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	payload, err = c.readN(int(length))
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= maskKey[i%4]
+	}
+
+	return opcode, payload, nil
+}
+
+func (c *wsConn) readN(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.br, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}